@@ -7,6 +7,10 @@ package main
 
 import (
 	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 
 	"github.com/letsencrypt/boulder/Godeps/_workspace/src/github.com/cactus/go-statsd-client/statsd"
 	"github.com/letsencrypt/boulder/Godeps/_workspace/src/github.com/streadway/amqp"
@@ -26,42 +30,206 @@ import (
 	gorp "github.com/letsencrypt/boulder/Godeps/_workspace/src/gopkg.in/gorp.v1"
 )
 
-func setupClients(c cmd.Config) (rpc.CertificateAuthorityClient, chan *amqp.Error) {
-	ch := cmd.AmqpChannel(c.AMQP.Server)
-	closeChan := ch.NotifyClose(make(chan *amqp.Error, 1))
+// defaultBatchSize is used when the configuration does not specify how
+// many stale certificateStatus rows to pull per pass.
+const defaultBatchSize = 100
 
-	cac, err := rpc.NewCertificateAuthorityClient(c.AMQP.CA.Client, c.AMQP.CA.Server, ch)
-	cmd.FailOnError(err, "Unable to create CA client")
+// defaultNumWorkers bounds how many rows in a batch are renewed
+// concurrently.
+const defaultNumWorkers = 4
 
-	return cac, closeChan
+// defaultMaxBackoff caps the exponential backoff applied after a failed
+// CA RPC so a persistent outage doesn't stall the updater indefinitely.
+const defaultMaxBackoff = 5 * time.Minute
+
+// ocspStats is the subset of statsd.Statter that ocspUpdater needs,
+// factored out so renewOne/updateBatch can be tested against a fake
+// without satisfying all of statsd.Statter.
+type ocspStats interface {
+	Inc(stat string, value int64, rate float32) error
+	TimingDuration(stat string, delta time.Duration, rate float32) error
+	Gauge(stat string, value int64, rate float32) error
 }
 
-func updateOne(dbMap *gorp.DbMap, oldestLastUpdatedTime time.Time) {
-	log := blog.GetAuditLogger()
+// ocspLogger is the subset of *blog.AuditLogger that ocspUpdater needs,
+// factored out for the same reason as ocspStats.
+type ocspLogger interface {
+	Info(msg string)
+	Warning(msg string)
+	Err(msg string)
+}
 
-	tx, err := dbMap.Begin()
-	if err != nil {
-		tx.Rollback()
-		return
+// ocspUpdater renews OCSP responses that are approaching their
+// nextUpdate time, in batches, using a small worker pool.
+type ocspUpdater struct {
+	dbMap      *gorp.DbMap
+	cac        rpc.CertificateAuthorityClient
+	stats      ocspStats
+	log        ocspLogger
+	batchSize  int
+	numWorkers int
+	renewalCut time.Duration
+	shutdownWg sync.WaitGroup
+	shutdownCh chan struct{}
+}
+
+func newOCSPUpdater(dbMap *gorp.DbMap, cac rpc.CertificateAuthorityClient, stats ocspStats, log ocspLogger, batchSize, numWorkers int, renewalCut time.Duration) *ocspUpdater {
+	if batchSize <= 0 {
+		batchSize = defaultBatchSize
+	}
+	if numWorkers <= 0 {
+		numWorkers = 1
 	}
+	return &ocspUpdater{
+		dbMap:      dbMap,
+		cac:        cac,
+		stats:      stats,
+		log:        log,
+		batchSize:  batchSize,
+		numWorkers: numWorkers,
+		renewalCut: renewalCut,
+		shutdownCh: make(chan struct{}),
+	}
+}
 
-	// If there are fewer than this many days left before the currently-signed
-	// OCSP response expires, sign a new OCSP response.
-	var certificateStatus []core.CertificateStatus
-	result, err := tx.Select(&certificateStatus,
+// findStaleResponses selects the oldest certificateStatus rows whose
+// nextUpdate is within the renewal window, oldest ocspLastUpdated first,
+// so a row that was just refreshed goes behind every row that wasn't. A
+// row whose renewal failed keeps its old ocspLastUpdated and is picked
+// again on the next pass.
+func (updater *ocspUpdater) findStaleResponses() ([]core.CertificateStatus, error) {
+	var statuses []core.CertificateStatus
+	cutoff := time.Now().Add(updater.renewalCut)
+	_, err := updater.dbMap.Select(&statuses,
 		`SELECT * FROM certificateStatus
-		 WHERE ocspLastUpdated > ?
+		 WHERE ocspNextUpdate < ?
 		 ORDER BY ocspLastUpdated ASC
-		 LIMIT 1`, oldestLastUpdatedTime)
-
+		 LIMIT ?`, cutoff, updater.batchSize)
 	if err == sql.ErrNoRows {
-		log.Info("No OCSP responses needed.")
-		return
-	} else if err != nil {
-		log.Err("Error loading certificate status: " + err.Error())
-	} else {
-		log.Info(fmt.Sprintf("%+v\n", result))
+		return nil, nil
 	}
+	return statuses, err
+}
+
+// nextBackoff doubles the previous backoff, capped at defaultMaxBackoff.
+func nextBackoff(prev time.Duration) time.Duration {
+	next := prev * 2
+	if next > defaultMaxBackoff {
+		return defaultMaxBackoff
+	}
+	return next
+}
+
+// renewOne regenerates and stores the OCSP response for a single
+// certificateStatus row, retrying the CA RPC with exponential backoff.
+func (updater *ocspUpdater) renewOne(status core.CertificateStatus) error {
+	start := time.Now()
+	backoff := time.Second
+	var ocspResponse core.OCSPResponse
+	var err error
+	for attempt := 0; attempt < 5; attempt++ {
+		ocspResponse, err = updater.cac.GenerateOCSP(core.OCSPSigningRequest{
+			CertDER: status.CertDER,
+			Status:  status.Status,
+		})
+		if err == nil {
+			break
+		}
+		updater.log.Warning(fmt.Sprintf("GenerateOCSP failed for serial %s (attempt %d): %s", status.Serial, attempt+1, err))
+		time.Sleep(backoff)
+		backoff = nextBackoff(backoff)
+	}
+	if err != nil {
+		updater.stats.Inc("ocsp.failed", 1, 1.0)
+		return err
+	}
+
+	lag := time.Since(status.OCSPLastUpdated)
+
+	tx, err := updater.dbMap.Begin()
+	if err != nil {
+		return err
+	}
+	status.OCSPResponse = ocspResponse.Response
+	status.OCSPLastUpdated = time.Now()
+	if _, err := tx.Update(&status); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	updater.stats.Inc("ocsp.generated", 1, 1.0)
+	updater.stats.TimingDuration("ocsp.generate_latency", time.Since(start), 1.0)
+	updater.stats.Gauge("ocsp.lag_seconds", int64(lag.Seconds()), 1.0)
+	return nil
+}
+
+// updateBatch fans a batch of stale rows out across the worker pool and
+// waits for all of them to finish.
+func (updater *ocspUpdater) updateBatch(statuses []core.CertificateStatus) {
+	work := make(chan core.CertificateStatus)
+	var wg sync.WaitGroup
+	for i := 0; i < updater.numWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for status := range work {
+				if err := updater.renewOne(status); err != nil {
+					updater.log.Err(fmt.Sprintf("Failed to renew OCSP response for serial %s: %s", status.Serial, err))
+				}
+			}
+		}()
+	}
+	for _, status := range statuses {
+		work <- status
+	}
+	close(work)
+	wg.Wait()
+}
+
+// Run loops, renewing batches of stale OCSP responses, until Stop is
+// called. It returns once any in-flight batch has finished.
+func (updater *ocspUpdater) Run(tickInterval time.Duration) {
+	updater.shutdownWg.Add(1)
+	defer updater.shutdownWg.Done()
+
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+	for {
+		statuses, err := updater.findStaleResponses()
+		if err != nil {
+			updater.log.Err("Error loading certificate status: " + err.Error())
+		} else if len(statuses) == 0 {
+			updater.log.Info("No OCSP responses needed.")
+		} else {
+			updater.updateBatch(statuses)
+		}
+
+		select {
+		case <-updater.shutdownCh:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Stop requests that Run finish its current batch and exit, then blocks
+// until it has done so.
+func (updater *ocspUpdater) Stop() {
+	close(updater.shutdownCh)
+	updater.shutdownWg.Wait()
+}
+
+func setupClients(c cmd.Config) (rpc.CertificateAuthorityClient, chan *amqp.Error) {
+	ch := cmd.AmqpChannel(c.AMQP.Server)
+	closeChan := ch.NotifyClose(make(chan *amqp.Error, 1))
+
+	cac, err := rpc.NewCertificateAuthorityClient(c.AMQP.CA.Client, c.AMQP.CA.Server, ch)
+	cmd.FailOnError(err, "Unable to create CA client")
+
+	return cac, closeChan
 }
 
 func main() {
@@ -85,6 +253,7 @@ func main() {
 			panic(err)
 		}
 		dbMap.AddTableWithName(core.OcspResponse{}, "ocspResponses").SetKeys(true, "ID")
+		dbMap.AddTableWithName(core.CertificateStatus{}, "certificateStatus").SetKeys(false, "Serial")
 
 		cac, closeChan := setupClients(c)
 
@@ -102,15 +271,27 @@ func main() {
 			}
 		}()
 
-		// Calculate the cut-off timestamp
-		dur, err := time.ParseDuration(c.OCSP.MinTimeToExpiry)
+		// Renew if less than this much time remains before nextUpdate.
+		renewalWindow, err := time.ParseDuration(c.OCSP.MinTimeToExpiry)
 		if err != nil {
 			panic(err)
 		}
-		oldestLastUpdatedTime := time.Now().Add(dur)
-		auditlogger.Info(fmt.Sprintf("Searching for OCSP reponses older than %s", oldestLastUpdatedTime))
+		auditlogger.Info(fmt.Sprintf("Searching for OCSP responses expiring within %s", renewalWindow))
+
+		// TODO(cmd.Config): surface batch size and worker count as config
+		// fields once the OCSP config struct grows them; until then these
+		// defaults apply to every deployment.
+		updater := newOCSPUpdater(dbMap, cac, stats, auditlogger, defaultBatchSize, defaultNumWorkers, renewalWindow)
+
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
+		go func() {
+			<-stop
+			auditlogger.Info("Received shutdown signal, finishing in-flight work")
+			updater.Stop()
+		}()
 
-		updateOne(dbMap, oldestLastUpdatedTime)
+		updater.Run(time.Minute)
 	}
 
 	app.Run()
@@ -0,0 +1,305 @@
+// Copyright 2014 ISRG.  All rights reserved
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/letsencrypt/boulder/Godeps/_workspace/src/github.com/mattn/go-sqlite3"
+	gorp "github.com/letsencrypt/boulder/Godeps/_workspace/src/gopkg.in/gorp.v1"
+
+	"github.com/letsencrypt/boulder/core"
+)
+
+func TestNextBackoffDoubles(t *testing.T) {
+	got := nextBackoff(time.Second)
+	if got != 2*time.Second {
+		t.Errorf("nextBackoff(1s) = %s, want 2s", got)
+	}
+}
+
+func TestNextBackoffCapsAtMax(t *testing.T) {
+	got := nextBackoff(defaultMaxBackoff)
+	if got != defaultMaxBackoff {
+		t.Errorf("nextBackoff(max) = %s, want %s", got, defaultMaxBackoff)
+	}
+
+	got = nextBackoff(defaultMaxBackoff/2 + time.Second)
+	if got != defaultMaxBackoff {
+		t.Errorf("nextBackoff(just over half max) = %s, want %s", got, defaultMaxBackoff)
+	}
+}
+
+// fakeStats is a no-op ocspStats that just counts calls, so tests can
+// assert renewOne reports success/failure without a real statsd server.
+type fakeStats struct {
+	mu      sync.Mutex
+	incs    map[string]int
+	timings int
+	gauges  map[string]int64
+}
+
+func newFakeStats() *fakeStats {
+	return &fakeStats{incs: map[string]int{}, gauges: map[string]int64{}}
+}
+
+func (f *fakeStats) Inc(stat string, value int64, rate float32) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.incs[stat] += int(value)
+	return nil
+}
+
+func (f *fakeStats) TimingDuration(stat string, delta time.Duration, rate float32) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.timings++
+	return nil
+}
+
+func (f *fakeStats) Gauge(stat string, value int64, rate float32) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.gauges[stat] = value
+	return nil
+}
+
+// fakeLogger is a no-op ocspLogger that records what was logged, so
+// tests can assert on error/warning paths without a real syslog
+// connection.
+type fakeLogger struct {
+	mu       sync.Mutex
+	errs     []string
+	warnings []string
+}
+
+func (f *fakeLogger) Info(msg string) {}
+
+func (f *fakeLogger) Warning(msg string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.warnings = append(f.warnings, msg)
+}
+
+func (f *fakeLogger) Err(msg string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.errs = append(f.errs, msg)
+}
+
+// fakeCA is an rpc.CertificateAuthorityClient that either always
+// succeeds or fails a fixed number of times before succeeding, so
+// renewOne's retry/backoff path can be exercised without a real CA.
+type fakeCA struct {
+	mu          sync.Mutex
+	failures    int
+	calls       int
+	failWithErr error
+}
+
+func (ca *fakeCA) GenerateOCSP(req core.OCSPSigningRequest) (core.OCSPResponse, error) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	ca.calls++
+	if ca.calls <= ca.failures {
+		return core.OCSPResponse{}, ca.failWithErr
+	}
+	return core.OCSPResponse{Response: []byte("ocsp-response")}, nil
+}
+
+// newTestDbMap sets up an in-memory sqlite certificateStatus table,
+// mirroring how main() configures dbMap, so findStaleResponses/
+// renewOne/updateBatch can be tested against real SQL instead of a
+// hand-rolled fake of gorp's query/transaction API.
+func newTestDbMap(t *testing.T) *gorp.DbMap {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("opening sqlite3: %s", err)
+	}
+	dbMap := &gorp.DbMap{Db: db, Dialect: gorp.SqliteDialect{}}
+	dbMap.AddTableWithName(core.CertificateStatus{}, "certificateStatus").SetKeys(false, "Serial")
+	if err := dbMap.CreateTablesIfNotExists(); err != nil {
+		t.Fatalf("creating tables: %s", err)
+	}
+	return dbMap
+}
+
+func insertStatus(t *testing.T, dbMap *gorp.DbMap, serial string, nextUpdate time.Time) {
+	status := &core.CertificateStatus{
+		Serial:          serial,
+		CertDER:         []byte("cert-der"),
+		Status:          core.StatusValid,
+		OCSPLastUpdated: nextUpdate.Add(-24 * time.Hour),
+		OCSPNextUpdate:  nextUpdate,
+	}
+	if err := dbMap.Insert(status); err != nil {
+		t.Fatalf("inserting certificateStatus row %s: %s", serial, err)
+	}
+}
+
+func TestFindStaleResponsesOnlyReturnsRowsDueForRenewal(t *testing.T) {
+	dbMap := newTestDbMap(t)
+	now := time.Now()
+	insertStatus(t, dbMap, "stale", now.Add(-time.Hour))
+	insertStatus(t, dbMap, "fresh", now.Add(30*24*time.Hour))
+
+	updater := newOCSPUpdater(dbMap, &fakeCA{}, newFakeStats(), &fakeLogger{}, 10, 1, time.Hour)
+
+	statuses, err := updater.findStaleResponses()
+	if err != nil {
+		t.Fatalf("findStaleResponses returned error: %s", err)
+	}
+	if len(statuses) != 1 || statuses[0].Serial != "stale" {
+		t.Errorf("findStaleResponses returned %+v, want only the stale row", statuses)
+	}
+}
+
+func TestFindStaleResponsesRespectsBatchSize(t *testing.T) {
+	dbMap := newTestDbMap(t)
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		insertStatus(t, dbMap, string(rune('a'+i)), now.Add(-time.Hour))
+	}
+
+	updater := newOCSPUpdater(dbMap, &fakeCA{}, newFakeStats(), &fakeLogger{}, 2, 1, time.Hour)
+
+	statuses, err := updater.findStaleResponses()
+	if err != nil {
+		t.Fatalf("findStaleResponses returned error: %s", err)
+	}
+	if len(statuses) != 2 {
+		t.Errorf("findStaleResponses returned %d rows, want batchSize of 2", len(statuses))
+	}
+}
+
+func TestRenewOneUpdatesResponseAndStats(t *testing.T) {
+	dbMap := newTestDbMap(t)
+	insertStatus(t, dbMap, "serial-1", time.Now().Add(-time.Hour))
+
+	stats := newFakeStats()
+	updater := newOCSPUpdater(dbMap, &fakeCA{}, stats, &fakeLogger{}, 10, 1, time.Hour)
+
+	var status core.CertificateStatus
+	if err := dbMap.SelectOne(&status, "SELECT * FROM certificateStatus WHERE serial = ?", "serial-1"); err != nil {
+		t.Fatalf("loading row: %s", err)
+	}
+
+	if err := updater.renewOne(status); err != nil {
+		t.Fatalf("renewOne returned error: %s", err)
+	}
+
+	var reloaded core.CertificateStatus
+	if err := dbMap.SelectOne(&reloaded, "SELECT * FROM certificateStatus WHERE serial = ?", "serial-1"); err != nil {
+		t.Fatalf("reloading row: %s", err)
+	}
+	if string(reloaded.OCSPResponse) != "ocsp-response" {
+		t.Errorf("OCSPResponse = %q, want %q", reloaded.OCSPResponse, "ocsp-response")
+	}
+	if stats.incs["ocsp.generated"] != 1 {
+		t.Errorf("ocsp.generated incremented %d times, want 1", stats.incs["ocsp.generated"])
+	}
+	if stats.timings != 1 {
+		t.Errorf("ocsp.generate_latency timed %d times, want 1", stats.timings)
+	}
+}
+
+func TestRenewOneRetriesThenSucceeds(t *testing.T) {
+	dbMap := newTestDbMap(t)
+	insertStatus(t, dbMap, "serial-2", time.Now().Add(-time.Hour))
+
+	var status core.CertificateStatus
+	if err := dbMap.SelectOne(&status, "SELECT * FROM certificateStatus WHERE serial = ?", "serial-2"); err != nil {
+		t.Fatalf("loading row: %s", err)
+	}
+
+	ca := &fakeCA{failures: 2, failWithErr: errors.New("CA temporarily unavailable")}
+	logger := &fakeLogger{}
+	updater := newOCSPUpdater(dbMap, ca, newFakeStats(), logger, 10, 1, time.Hour)
+
+	if err := updater.renewOne(status); err != nil {
+		t.Fatalf("renewOne returned error after exhausting failures: %s", err)
+	}
+	if ca.calls != 3 {
+		t.Errorf("GenerateOCSP called %d times, want 3 (2 failures + 1 success)", ca.calls)
+	}
+	if len(logger.warnings) != 2 {
+		t.Errorf("logged %d warnings, want 2 for the 2 failed attempts", len(logger.warnings))
+	}
+}
+
+func TestRenewOneGivesUpAfterAllAttemptsFail(t *testing.T) {
+	dbMap := newTestDbMap(t)
+	insertStatus(t, dbMap, "serial-3", time.Now().Add(-time.Hour))
+
+	var status core.CertificateStatus
+	if err := dbMap.SelectOne(&status, "SELECT * FROM certificateStatus WHERE serial = ?", "serial-3"); err != nil {
+		t.Fatalf("loading row: %s", err)
+	}
+
+	ca := &fakeCA{failures: 5, failWithErr: errors.New("CA down")}
+	stats := newFakeStats()
+	updater := newOCSPUpdater(dbMap, ca, stats, &fakeLogger{}, 10, 1, time.Hour)
+
+	if err := updater.renewOne(status); err == nil {
+		t.Fatal("expected renewOne to return an error once every attempt fails")
+	}
+	if stats.incs["ocsp.failed"] != 1 {
+		t.Errorf("ocsp.failed incremented %d times, want 1", stats.incs["ocsp.failed"])
+	}
+}
+
+func TestUpdateBatchRenewsEveryRowAcrossWorkers(t *testing.T) {
+	dbMap := newTestDbMap(t)
+	now := time.Now()
+	serials := []string{"w1", "w2", "w3", "w4", "w5"}
+	for _, serial := range serials {
+		insertStatus(t, dbMap, serial, now.Add(-time.Hour))
+	}
+
+	var rows []core.CertificateStatus
+	if _, err := dbMap.Select(&rows, "SELECT * FROM certificateStatus"); err != nil {
+		t.Fatalf("loading rows: %s", err)
+	}
+
+	ca := &fakeCA{}
+	updater := newOCSPUpdater(dbMap, ca, newFakeStats(), &fakeLogger{}, 10, 3, time.Hour)
+	updater.updateBatch(rows)
+
+	if ca.calls != len(serials) {
+		t.Errorf("GenerateOCSP called %d times, want %d (one per row)", ca.calls, len(serials))
+	}
+
+	var remaining []core.CertificateStatus
+	if _, err := dbMap.Select(&remaining, "SELECT * FROM certificateStatus WHERE ocspResponse IS NULL"); err != nil {
+		t.Fatalf("checking remaining rows: %s", err)
+	}
+	if len(remaining) != 0 {
+		t.Errorf("%d rows were never renewed by updateBatch", len(remaining))
+	}
+}
+
+func TestUpdateBatchLogsPerRowFailures(t *testing.T) {
+	dbMap := newTestDbMap(t)
+	insertStatus(t, dbMap, "bad-row", time.Now().Add(-time.Hour))
+
+	var rows []core.CertificateStatus
+	if _, err := dbMap.Select(&rows, "SELECT * FROM certificateStatus"); err != nil {
+		t.Fatalf("loading rows: %s", err)
+	}
+
+	ca := &fakeCA{failures: 5, failWithErr: errors.New("CA down")}
+	logger := &fakeLogger{}
+	updater := newOCSPUpdater(dbMap, ca, newFakeStats(), logger, 10, 1, time.Hour)
+	updater.updateBatch(rows)
+
+	if len(logger.errs) != 1 {
+		t.Errorf("logged %d errors, want 1 for the row that never succeeded", len(logger.errs))
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright 2014 ISRG.  All rights reserved
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package core
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"time"
+
+	"github.com/letsencrypt/boulder/jose"
+)
+
+// TemplateContext is exposed to certificate templates so they can make
+// issuance decisions based on the account and on how each name in the
+// request was validated.
+type TemplateContext struct {
+	Registration Registration
+	// Authorizations are the validated authorizations backing this
+	// issuance, keyed by the identifier value they cover.
+	Authorizations map[string]Authorization
+	// ChallengeTypes maps each authorized name to the type of the
+	// challenge that was used to validate it, e.g. "dns-01".
+	ChallengeTypes map[string]string
+	CSR            x509.CertificateRequest
+	JWK            jose.JsonWebKey
+}
+
+// CertificateModifications are the fields a template may set or override
+// before issuance. A zero value for a field leaves the CA's default
+// behavior for that field unchanged.
+type CertificateModifications struct {
+	NotAfter    time.Time
+	KeyUsage    x509.KeyUsage
+	ExtKeyUsage []x509.ExtKeyUsage
+	PolicyOIDs  []asn1.ObjectIdentifier
+	CTPoison    bool
+
+	// TLSFeatures lists the TLS Feature (RFC 7633) feature codes the
+	// issued certificate should assert, e.g. 5 for status_request
+	// (OCSP must-staple). A CA supporting this field encodes it as the
+	// SEQUENCE of INTEGER values required by the id-pe-tlsfeature
+	// extension (OID 1.3.6.1.5.5.7.1.24); it is distinct from
+	// PolicyOIDs, which populates the Certificate Policies extension.
+	TLSFeatures []int
+}
+
+// CertificateTemplate inspects a TemplateContext and may adjust mods in
+// place, or reject issuance outright by returning an error.
+type CertificateTemplate interface {
+	Name() string
+	Apply(ctx TemplateContext, mods *CertificateModifications) error
+}
+
+// TemplatingCertificateAuthority is implemented by a CertificateAuthority
+// that accepts CertificateModifications alongside a CSR. It's kept as a
+// separate, optional interface (rather than a breaking change to
+// CertificateAuthority) so existing implementations that don't support
+// templating keep working unmodified.
+type TemplatingCertificateAuthority interface {
+	IssueCertificateWithTemplate(csr x509.CertificateRequest, mods CertificateModifications) (Certificate, error)
+}
@@ -0,0 +1,79 @@
+// Copyright 2014 ISRG.  All rights reserved
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package core
+
+// ChallengeTypeTLSALPN01 identifies the TLS-ALPN-01 challenge defined in
+// RFC 8737. It validates control of a name by requiring the identifier
+// owner to present a self-signed certificate over a TLS connection
+// negotiated with the "acme-tls/1" ALPN protocol.
+const ChallengeTypeTLSALPN01 = "tls-alpn-01"
+
+// TLSALPNProtocol is the ALPN protocol name a client must offer, and a
+// server must select, during the TLS-ALPN-01 handshake.
+const TLSALPNProtocol = "acme-tls/1"
+
+// IdPeACMEIdentifier is the OID of the critical X.509 extension that
+// carries the SHA-256 digest of the key authorization in a TLS-ALPN-01
+// validation certificate.
+var IdPeACMEIdentifier = []int{1, 3, 6, 1, 5, 5, 7, 1, 31}
+
+// TLSALPNChallenge constructs a pending TLS-ALPN-01 challenge.
+func TLSALPNChallenge() Challenge {
+	return Challenge{
+		Type:   ChallengeTypeTLSALPN01,
+		Status: StatusPending,
+		Token:  NewToken(),
+	}
+}
+
+// ChallengeTypeSimpleHTTPS and ChallengeTypeDVSNI identify the two
+// original challenge types alongside ChallengeTypeTLSALPN01.
+const (
+	ChallengeTypeSimpleHTTPS = "simpleHttps"
+	ChallengeTypeDVSNI       = "dvsni"
+)
+
+// ChallengeTypeDNS01 identifies the dns-01 challenge: proving control of
+// an identifier by publishing a TXT record under
+// "_acme-challenge.<identifier>" containing the base64url-encoded
+// SHA-256 digest of the key authorization.
+const ChallengeTypeDNS01 = "dns-01"
+
+// DNS01Challenge constructs a pending dns-01 challenge.
+func DNS01Challenge() Challenge {
+	return Challenge{
+		Type:   ChallengeTypeDNS01,
+		Status: StatusPending,
+		Token:  NewToken(),
+	}
+}
+
+// DefaultChallengeTypes lists the challenge types offered to clients when
+// a RegistrationAuthorityImpl is not configured with an explicit list.
+var DefaultChallengeTypes = []string{
+	ChallengeTypeSimpleHTTPS,
+	ChallengeTypeDVSNI,
+	ChallengeTypeTLSALPN01,
+	ChallengeTypeDNS01,
+}
+
+// ChallengeForType constructs a pending challenge of the given type, for
+// use by callers (like the RA) that build the set of challenges to offer
+// from a configured list rather than hardcoding it.
+func ChallengeForType(challengeType string) (Challenge, error) {
+	switch challengeType {
+	case ChallengeTypeSimpleHTTPS:
+		return SimpleHTTPSChallenge(), nil
+	case ChallengeTypeDVSNI:
+		return DvsniChallenge(), nil
+	case ChallengeTypeTLSALPN01:
+		return TLSALPNChallenge(), nil
+	case ChallengeTypeDNS01:
+		return DNS01Challenge(), nil
+	default:
+		return Challenge{}, NotSupportedError("Unknown challenge type: " + challengeType)
+	}
+}
@@ -0,0 +1,27 @@
+// Copyright 2014 ISRG.  All rights reserved
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package core
+
+// ChallengeSolver separates the orchestration of a challenge (deciding
+// which challenges to offer, tracking their state) from the wire-format
+// specific work of proving and checking control of an identifier. The VA
+// looks up a ChallengeSolver by challenge type and drives it through the
+// Present/Verify/CleanUp lifecycle instead of switching on challenge type
+// inline, so new challenge types (e.g. a dns-01 solver backed by RFC 2136
+// dynamic updates) can be added without modifying core.
+type ChallengeSolver interface {
+	// Present prepares whatever the solver needs in place before
+	// validation can be attempted, e.g. provisioning a DNS record.
+	Present(authz Authorization, chal Challenge) error
+
+	// Verify checks whether the identifier owner satisfied the
+	// challenge, and returns the resulting status for it.
+	Verify(authz Authorization, chal Challenge) (AcmeStatus, error)
+
+	// CleanUp removes anything Present set up, regardless of whether
+	// validation succeeded.
+	CleanUp(authz Authorization, chal Challenge) error
+}
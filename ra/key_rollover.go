@@ -0,0 +1,122 @@
+// Copyright 2014 ISRG.  All rights reserved
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package ra
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/letsencrypt/boulder/core"
+	"github.com/letsencrypt/boulder/jose"
+	blog "github.com/letsencrypt/boulder/log"
+)
+
+// rolloverRequest is the payload of the inner JWS in a key rollover
+// request, per RFC 8555 section 7.3.5. It is signed by the new key and
+// binds the rollover to a specific account and old key so it can't be
+// replayed against a different account.
+type rolloverRequest struct {
+	Account string          `json:"account"`
+	OldKey  jose.JsonWebKey `json:"oldKey"`
+	Nonce   string          `json:"nonce"`
+}
+
+// registrationURL returns the canonical account URL for a registration
+// ID, in the same style NewAuthorization uses to mint authorization
+// URLs from AuthzBase.
+func (ra *RegistrationAuthorityImpl) registrationURL(id int64) string {
+	return fmt.Sprintf("%s%d", ra.RegBase, id)
+}
+
+// keyRolloverStorage is the subset of core.StorageAuthority that
+// rolloverKey needs. Factoring it out lets the replay, collision, and
+// account-binding logic below be tested against a small fake store
+// instead of requiring a fake that satisfies all of
+// core.StorageAuthority.
+type keyRolloverStorage interface {
+	GetRegistrationByKey(jwk jose.JsonWebKey) (core.Registration, error)
+	UpdateRegistration(reg core.Registration) error
+	ConsumeNonce(nonce string) (bool, error)
+}
+
+// KeyRollover changes the key associated with a registration. innerJWS
+// must be signed by the new key (with the key embedded in its header,
+// per the ACME key-rollover flow) over a rolloverRequest payload that
+// names the account and the thumbprint-equivalent old key; this proves
+// the caller controls both the old and new keys without trusting the
+// outer, already-authenticated request alone.
+func (ra *RegistrationAuthorityImpl) KeyRollover(oldKey jose.JsonWebKey, innerJWS jose.JsonWebSignature) (reg core.Registration, err error) {
+	newKey, payloadBytes, err := innerJWS.VerifySelfSigned()
+	if err != nil {
+		err = core.UnauthorizedError("Inner JWS did not verify against its embedded key: " + err.Error())
+		return
+	}
+
+	var request rolloverRequest
+	if err = json.Unmarshal(payloadBytes, &request); err != nil {
+		err = core.MalformedRequestError("Inner JWS payload was not a valid key rollover request")
+		return
+	}
+
+	return rolloverKey(ra.SA, ra.registrationURL, oldKey, newKey, request)
+}
+
+// rolloverKey applies an already-JWS-verified key rollover: it binds
+// the request to the expected old key and account, rejects a new key
+// already bound to a different registration, atomically consumes the
+// rollover nonce, and swaps in the new key. Split out from KeyRollover
+// so this logic can be tested against a fake keyRolloverStorage
+// without needing a real jose.JsonWebSignature.
+func rolloverKey(store keyRolloverStorage, registrationURL func(int64) string, oldKey, newKey jose.JsonWebKey, request rolloverRequest) (reg core.Registration, err error) {
+	if !request.OldKey.Equals(oldKey) {
+		err = core.MalformedRequestError("Inner JWS does not bind the expected old key")
+		return
+	}
+
+	reg, err = store.GetRegistrationByKey(oldKey)
+	if err != nil {
+		err = core.NotFoundError("No registration found for the supplied key")
+		return
+	}
+
+	if request.Account != registrationURL(reg.ID) {
+		err = core.MalformedRequestError("Inner JWS does not bind the expected account")
+		return
+	}
+
+	if existing, lookupErr := store.GetRegistrationByKey(newKey); lookupErr == nil && existing.ID != reg.ID {
+		err = core.UnauthorizedError("The new key is already bound to a different registration")
+		return
+	}
+
+	// ConsumeNonce atomically checks and marks the nonce used in one
+	// step, so two concurrent requests carrying the same nonce can't
+	// both pass the check before either marks it spent. It's called
+	// before the key swap (rather than after, as a final step) so a
+	// replayed request always fails the same way — on the nonce check —
+	// instead of succeeding a second time or leaving the first
+	// successful rollover looking like it failed.
+	consumed, nonceErr := store.ConsumeNonce(request.Nonce)
+	if nonceErr != nil {
+		err = core.InternalServerError("Could not consume rollover nonce: " + nonceErr.Error())
+		return
+	}
+	if !consumed {
+		err = core.UnauthorizedError("Key rollover nonce has already been used")
+		return
+	}
+
+	oldThumbprint, _ := oldKey.Thumbprint()
+	reg.Key = newKey
+	if err = store.UpdateRegistration(reg); err != nil {
+		return
+	}
+
+	blog.GetAuditLogger().Audit(fmt.Sprintf(
+		"Key rollover: registration ID %d rolled over from key thumbprint %s", reg.ID, oldThumbprint))
+
+	return
+}
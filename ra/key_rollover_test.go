@@ -0,0 +1,166 @@
+// Copyright 2014 ISRG.  All rights reserved
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package ra
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/letsencrypt/boulder/core"
+	"github.com/letsencrypt/boulder/jose"
+)
+
+// fakeRegResult is one scripted return value for a call to
+// fakeKeyRolloverStore.GetRegistrationByKey.
+type fakeRegResult struct {
+	reg core.Registration
+	err error
+}
+
+// fakeKeyRolloverStore is a keyRolloverStorage that scripts its
+// GetRegistrationByKey results by call order (first call is always the
+// old-key lookup, second is the new-key collision check) and records
+// what rolloverKey does with ConsumeNonce/UpdateRegistration, without
+// needing to satisfy all of core.StorageAuthority.
+type fakeKeyRolloverStore struct {
+	getResults []fakeRegResult
+	getCalls   int
+
+	consumeNonceOK  bool
+	consumeNonceErr error
+	nonceCalls      int
+
+	updateErr    error
+	updatedReg   core.Registration
+	updateCalled bool
+}
+
+func (f *fakeKeyRolloverStore) GetRegistrationByKey(jwk jose.JsonWebKey) (core.Registration, error) {
+	r := f.getResults[f.getCalls]
+	f.getCalls++
+	return r.reg, r.err
+}
+
+func (f *fakeKeyRolloverStore) UpdateRegistration(reg core.Registration) error {
+	f.updateCalled = true
+	f.updatedReg = reg
+	return f.updateErr
+}
+
+func (f *fakeKeyRolloverStore) ConsumeNonce(nonce string) (bool, error) {
+	f.nonceCalls++
+	return f.consumeNonceOK, f.consumeNonceErr
+}
+
+func testRegistrationURL(id int64) string {
+	return fmt.Sprintf("https://example.com/acme/reg/%d", id)
+}
+
+func TestRolloverKeySucceeds(t *testing.T) {
+	store := &fakeKeyRolloverStore{
+		getResults: []fakeRegResult{
+			{reg: core.Registration{ID: 1}},                   // old-key lookup
+			{err: core.NotFoundError("no such registration")}, // new-key lookup: no collision
+		},
+		consumeNonceOK: true,
+	}
+	request := rolloverRequest{Account: testRegistrationURL(1), Nonce: "abc"}
+	newKey := jose.JsonWebKey{}
+
+	reg, err := rolloverKey(store, testRegistrationURL, jose.JsonWebKey{}, newKey, request)
+	if err != nil {
+		t.Fatalf("rolloverKey returned error: %s", err)
+	}
+	if reg.ID != 1 {
+		t.Errorf("reg.ID = %d, want 1", reg.ID)
+	}
+	if !store.updateCalled {
+		t.Error("expected UpdateRegistration to be called")
+	}
+	if store.nonceCalls != 1 {
+		t.Errorf("ConsumeNonce called %d times, want 1", store.nonceCalls)
+	}
+}
+
+func TestRolloverKeyRejectsReplayedNonce(t *testing.T) {
+	store := &fakeKeyRolloverStore{
+		getResults: []fakeRegResult{
+			{reg: core.Registration{ID: 1}},
+			{err: core.NotFoundError("no such registration")},
+		},
+		consumeNonceOK: false, // nonce already used
+	}
+	request := rolloverRequest{Account: testRegistrationURL(1), Nonce: "replayed"}
+
+	_, err := rolloverKey(store, testRegistrationURL, jose.JsonWebKey{}, jose.JsonWebKey{}, request)
+	if err == nil {
+		t.Fatal("expected an error for a replayed nonce")
+	}
+	if store.updateCalled {
+		t.Error("expected UpdateRegistration not to be called when the nonce was already used")
+	}
+}
+
+func TestRolloverKeyRejectsAccountMismatch(t *testing.T) {
+	store := &fakeKeyRolloverStore{
+		getResults: []fakeRegResult{
+			{reg: core.Registration{ID: 1}},
+		},
+		consumeNonceOK: true,
+	}
+	request := rolloverRequest{Account: testRegistrationURL(999), Nonce: "abc"}
+
+	_, err := rolloverKey(store, testRegistrationURL, jose.JsonWebKey{}, jose.JsonWebKey{}, request)
+	if err == nil {
+		t.Fatal("expected an error when the account field doesn't match the registration")
+	}
+	if store.nonceCalls != 0 {
+		t.Error("expected the nonce not to be consumed when the account check fails first")
+	}
+	if store.updateCalled {
+		t.Error("expected UpdateRegistration not to be called when the account check fails")
+	}
+}
+
+func TestRolloverKeyRejectsKeyAlreadyBoundElsewhere(t *testing.T) {
+	store := &fakeKeyRolloverStore{
+		getResults: []fakeRegResult{
+			{reg: core.Registration{ID: 1}}, // old-key lookup
+			{reg: core.Registration{ID: 2}}, // new-key already belongs to a different registration
+		},
+		consumeNonceOK: true,
+	}
+	request := rolloverRequest{Account: testRegistrationURL(1), Nonce: "abc"}
+
+	_, err := rolloverKey(store, testRegistrationURL, jose.JsonWebKey{}, jose.JsonWebKey{}, request)
+	if err == nil {
+		t.Fatal("expected an error when the new key is already bound to a different registration")
+	}
+	if store.nonceCalls != 0 {
+		t.Error("expected the nonce not to be consumed when the collision check fails")
+	}
+	if store.updateCalled {
+		t.Error("expected UpdateRegistration not to be called when the collision check fails")
+	}
+}
+
+func TestRegistrationURL(t *testing.T) {
+	ra := &RegistrationAuthorityImpl{RegBase: "https://example.com/acme/reg/"}
+
+	got := ra.registrationURL(1)
+	want := "https://example.com/acme/reg/1"
+	if got != want {
+		t.Errorf("registrationURL(1) = %q, want %q", got, want)
+	}
+}
+
+func TestRegistrationURLDistinguishesIDs(t *testing.T) {
+	ra := &RegistrationAuthorityImpl{RegBase: "https://example.com/acme/reg/"}
+
+	if ra.registrationURL(1) == ra.registrationURL(2) {
+		t.Error("expected different registration IDs to produce different URLs")
+	}
+}
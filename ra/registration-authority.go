@@ -25,6 +25,25 @@ type RegistrationAuthorityImpl struct {
 	SA core.StorageAuthority
 
 	AuthzBase string
+
+	// RegBase is the base URL under which registration (account) URLs
+	// are minted, e.g. for comparison against the "account" field of a
+	// key rollover request. See KeyRollover.
+	RegBase string
+
+	// ChallengeTypes lists the challenge types offered in new
+	// authorizations. If empty, core.DefaultChallengeTypes is used.
+	// Operators can trim or reorder this list without any change to the
+	// RA. Every entry must be a type core.ChallengeForType can construct;
+	// any other type, even one with a solver registered with the VA,
+	// makes NewAuthorization fail.
+	ChallengeTypes []string
+
+	// Templates runs, in order, just before issuance in NewCertificate,
+	// letting operators adjust or reject a certificate based on its
+	// authorizations. See ShortLivedLeafTemplate and MustStapleTemplate
+	// for built-ins, and LoadTemplatesFromDir to add more from disk.
+	Templates []core.CertificateTemplate
 }
 
 func NewRegistrationAuthorityImpl() RegistrationAuthorityImpl {
@@ -121,17 +140,27 @@ func (ra *RegistrationAuthorityImpl) NewAuthorization(request core.Authorization
 
 	// Create validations
 	// TODO: Assign URLs
-	simpleHttps := core.SimpleHTTPSChallenge()
-	dvsni := core.DvsniChallenge()
+	challengeTypes := ra.ChallengeTypes
+	if len(challengeTypes) == 0 {
+		challengeTypes = core.DefaultChallengeTypes
+	}
+
 	authID, err := ra.SA.NewPendingAuthorization()
 	if err != nil {
 		return
 	}
-	// Ignoring these errors because we construct the URLs to be correct
-	simpleHTTPSURI, _ := url.Parse(ra.AuthzBase + authID + "?" + core.RandomString(4))
-	dvsniURI, _ := url.Parse(ra.AuthzBase + authID + "?" + core.RandomString(4))
-	simpleHttps.URI = core.AcmeURL(*simpleHTTPSURI)
-	dvsni.URI = core.AcmeURL(*dvsniURI)
+
+	challenges := make([]core.Challenge, len(challengeTypes))
+	for i, challengeType := range challengeTypes {
+		chal, err := core.ChallengeForType(challengeType)
+		if err != nil {
+			return authz, err
+		}
+		// Ignoring this error because we construct the URL to be correct
+		chalURI, _ := url.Parse(ra.AuthzBase + authID + "?" + core.RandomString(4))
+		chal.URI = core.AcmeURL(*chalURI)
+		challenges[i] = chal
+	}
 
 	// Create a new authorization object
 	authz = core.Authorization{
@@ -139,10 +168,7 @@ func (ra *RegistrationAuthorityImpl) NewAuthorization(request core.Authorization
 		Identifier: identifier,
 		Key:        key,
 		Status:     core.StatusPending,
-		Challenges: []core.Challenge{
-			simpleHttps,
-			dvsni,
-		},
+		Challenges: challenges,
 	}
 
 	// Store the authorization object, then return it
@@ -160,8 +186,16 @@ func (ra *RegistrationAuthorityImpl) NewCertificate(req core.CertificateRequest,
 		return
 	}
 
+	reg, err := ra.SA.GetRegistrationByKey(jwk)
+	if err != nil {
+		err = core.UnauthorizedError("No registration found for the requesting key")
+		return
+	}
+
 	// Gather authorized domains from the referenced authorizations
 	authorizedDomains := map[string]bool{}
+	validatedAuthzs := map[string]core.Authorization{}
+	challengeTypes := map[string]string{}
 	now := time.Now()
 	for _, url := range req.Authorizations {
 		id := lastPathSegment(url)
@@ -178,6 +212,13 @@ func (ra *RegistrationAuthorityImpl) NewCertificate(req core.CertificateRequest,
 		}
 
 		authorizedDomains[authz.Identifier.Value] = true
+		validatedAuthzs[authz.Identifier.Value] = authz
+		for _, chal := range authz.Challenges {
+			if chal.Status == core.StatusValid {
+				challengeTypes[authz.Identifier.Value] = chal.Type
+				break
+			}
+		}
 	}
 
 	// Validate that authorization key is authorized for all domains
@@ -192,6 +233,30 @@ func (ra *RegistrationAuthorityImpl) NewCertificate(req core.CertificateRequest,
 		}
 	}
 
+	// Run the template pipeline, if any templates are configured, to let
+	// operators adjust per-issuance certificate properties based on the
+	// account and how each name was validated.
+	mods, err := runTemplates(ra.Templates, core.TemplateContext{
+		Registration:   reg,
+		Authorizations: validatedAuthzs,
+		ChallengeTypes: challengeTypes,
+		CSR:            *csr,
+		JWK:            jwk,
+	})
+	if err != nil {
+		return
+	}
+
+	if len(ra.Templates) > 0 {
+		tca, ok := ra.CA.(core.TemplatingCertificateAuthority)
+		if !ok {
+			err = core.InternalServerError("Templates are configured but the CA does not support templated issuance")
+			return
+		}
+		cert, err = tca.IssueCertificateWithTemplate(*csr, mods)
+		return
+	}
+
 	// Create the certificate
 	cert, err = ra.CA.IssueCertificate(*csr)
 	return
@@ -216,8 +281,11 @@ func (ra *RegistrationAuthorityImpl) UpdateAuthorization(base core.Authorization
 	// Store the updated version
 	ra.SA.UpdatePendingAuthorization(authz)
 
-	// Dispatch to the VA for service
-	ra.VA.UpdateValidations(authz)
+	// Dispatch to the VA for service. Only the challenge the client just
+	// responded to is validated; the others are left pending so the
+	// client can retry a different challenge type later without
+	// triggering an unrelated, never-attempted validation.
+	ra.VA.UpdateValidations(authz, challengeIndex)
 
 	return
 }
@@ -227,25 +295,45 @@ func (ra *RegistrationAuthorityImpl) RevokeCertificate(cert x509.Certificate) er
 	return nil
 }
 
-func (ra *RegistrationAuthorityImpl) OnValidationUpdate(authz core.Authorization) {
-	// Check to see whether the updated validations are sufficient
-	// Current policy is to accept if any validation succeeded
-	for _, val := range authz.Challenges {
+// authzFinalStatus decides whether an authorization's challenges
+// warrant finalizing it now, and if so what status to finalize it
+// with. ready is false when at least one challenge is still Pending:
+// the client may yet retry a different challenge type, so the caller
+// should leave the authorization open rather than finalize it. It's
+// factored out of OnValidationUpdate so this policy can be tested
+// without a core.StorageAuthority.
+func authzFinalStatus(challenges []core.Challenge) (status core.AcmeStatus, ready bool) {
+	// Current policy is to accept as soon as any one challenge succeeds.
+	for _, val := range challenges {
 		if val.Status == core.StatusValid {
-			authz.Status = core.StatusValid
-			break
+			return core.StatusValid, true
+		}
+	}
+
+	// Don't give up after a single failed challenge: as long as another
+	// offered challenge is still Pending, the client may yet retry a
+	// different challenge type, so finalizing now as Invalid (a
+	// terminal state) would cut that retry off. Only finalize once
+	// every offered challenge has been attempted and none succeeded.
+	for _, val := range challenges {
+		if val.Status == core.StatusPending {
+			return "", false
 		}
 	}
 
-	// If no validation succeeded, then the authorization is invalid
-	// NOTE: This only works because we only ever do one validation
-	if authz.Status != core.StatusValid {
-		authz.Status = core.StatusInvalid
-	} else {
+	return core.StatusInvalid, true
+}
+
+func (ra *RegistrationAuthorityImpl) OnValidationUpdate(authz core.Authorization) {
+	status, ready := authzFinalStatus(authz.Challenges)
+	if !ready {
+		return
+	}
+
+	authz.Status = status
+	if status == core.StatusValid {
 		// TODO: Enable configuration of expiry time
 		authz.Expires = time.Now().Add(365 * 24 * time.Hour)
 	}
-
-	// Finalize the authorization
 	ra.SA.FinalizeAuthorization(authz)
-}
\ No newline at end of file
+}
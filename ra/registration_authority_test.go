@@ -0,0 +1,49 @@
+// Copyright 2014 ISRG.  All rights reserved
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package ra
+
+import (
+	"testing"
+
+	"github.com/letsencrypt/boulder/core"
+)
+
+func TestAuthzFinalStatusAcceptsOnFirstValid(t *testing.T) {
+	status, ready := authzFinalStatus([]core.Challenge{
+		{Status: core.StatusInvalid},
+		{Status: core.StatusValid},
+		{Status: core.StatusPending},
+	})
+	if !ready {
+		t.Fatal("expected ready once a challenge is valid")
+	}
+	if status != core.StatusValid {
+		t.Errorf("status = %v, want StatusValid", status)
+	}
+}
+
+func TestAuthzFinalStatusWaitsWhileAChallengeIsPending(t *testing.T) {
+	_, ready := authzFinalStatus([]core.Challenge{
+		{Status: core.StatusInvalid},
+		{Status: core.StatusPending},
+	})
+	if ready {
+		t.Error("expected not ready while another challenge is still pending")
+	}
+}
+
+func TestAuthzFinalStatusInvalidOnceAllAttemptsFail(t *testing.T) {
+	status, ready := authzFinalStatus([]core.Challenge{
+		{Status: core.StatusInvalid},
+		{Status: core.StatusInvalid},
+	})
+	if !ready {
+		t.Fatal("expected ready once every challenge has been attempted")
+	}
+	if status != core.StatusInvalid {
+		t.Errorf("status = %v, want StatusInvalid", status)
+	}
+}
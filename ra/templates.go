@@ -0,0 +1,232 @@
+// Copyright 2014 ISRG.  All rights reserved
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package ra
+
+import (
+	"bufio"
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/letsencrypt/boulder/core"
+)
+
+// shortLivedLeafTemplate shortens the validity period of the issued
+// certificate, for operators who want to limit the blast radius of a key
+// compromise instead of relying solely on revocation.
+type shortLivedLeafTemplate struct {
+	Validity time.Duration
+}
+
+func (t shortLivedLeafTemplate) Name() string { return "short-lived-leaf" }
+
+func (t shortLivedLeafTemplate) Apply(ctx core.TemplateContext, mods *core.CertificateModifications) error {
+	mods.NotAfter = time.Now().Add(t.Validity)
+	return nil
+}
+
+// ShortLivedLeafTemplate is the built-in "short-lived leaf" template,
+// issuing certificates valid for a week unless reconfigured.
+var ShortLivedLeafTemplate core.CertificateTemplate = shortLivedLeafTemplate{Validity: 7 * 24 * time.Hour}
+
+// statusRequestFeature is the TLS Feature (RFC 7633) feature code for
+// status_request, i.e. OCSP must-staple.
+const statusRequestFeature = 5
+
+// mustStapleTemplate sets the must-staple TLS Feature (RFC 7633) on
+// certificates it applies to, requiring clients to staple a valid OCSP
+// response.
+type mustStapleTemplate struct{}
+
+func (t mustStapleTemplate) Name() string { return "must-staple" }
+
+func (t mustStapleTemplate) Apply(ctx core.TemplateContext, mods *core.CertificateModifications) error {
+	mods.TLSFeatures = append(mods.TLSFeatures, statusRequestFeature)
+	return nil
+}
+
+// MustStapleTemplate is the built-in "must-staple" template.
+var MustStapleTemplate core.CertificateTemplate = mustStapleTemplate{}
+
+// templateFuncs is the safe function set available to on-disk templates:
+// pure functions over the TemplateContext's data, with no filesystem,
+// network, or process access.
+var templateFuncs = template.FuncMap{
+	"validatedBy": func(ctx core.TemplateContext, name string) string {
+		return ctx.ChallengeTypes[name]
+	},
+	"hasName": func(ctx core.TemplateContext, name string) bool {
+		_, ok := ctx.Authorizations[name]
+		return ok
+	},
+}
+
+// fileTemplate wraps a text/template loaded from disk. Executing it
+// against a TemplateContext renders a small directive script (one
+// "key: value" pair per line) which is then parsed into
+// CertificateModifications; the template itself never runs arbitrary
+// code, only the safe functions in templateFuncs.
+type fileTemplate struct {
+	name string
+	tmpl *template.Template
+}
+
+func (t *fileTemplate) Name() string { return t.name }
+
+func (t *fileTemplate) Apply(ctx core.TemplateContext, mods *core.CertificateModifications) error {
+	var buf strings.Builder
+	if err := t.tmpl.Execute(&buf, ctx); err != nil {
+		return core.InternalServerError(fmt.Sprintf("Template %s failed to execute: %s", t.name, err))
+	}
+	return applyDirectives(t.name, buf.String(), mods)
+}
+
+// keyUsageNames maps the directive vocabulary for "key_usage" to the
+// x509.KeyUsage bits a disk template can set. The names follow the RFC
+// 5280 KeyUsage named bits, except that keyCertSign and cRLSign are
+// spelled "certSign" and "crlSign", and nonRepudiation is accepted under
+// its later X.509 name "contentCommitment".
+var keyUsageNames = map[string]x509.KeyUsage{
+	"digitalSignature":  x509.KeyUsageDigitalSignature,
+	"contentCommitment": x509.KeyUsageContentCommitment,
+	"keyEncipherment":   x509.KeyUsageKeyEncipherment,
+	"dataEncipherment":  x509.KeyUsageDataEncipherment,
+	"keyAgreement":      x509.KeyUsageKeyAgreement,
+	"certSign":          x509.KeyUsageCertSign,
+	"crlSign":           x509.KeyUsageCRLSign,
+	"encipherOnly":      x509.KeyUsageEncipherOnly,
+	"decipherOnly":      x509.KeyUsageDecipherOnly,
+}
+
+// extKeyUsageNames maps the directive vocabulary for "ext_key_usage" to
+// x509.ExtKeyUsage values, using the same names as Go's x509 package.
+var extKeyUsageNames = map[string]x509.ExtKeyUsage{
+	"any":             x509.ExtKeyUsageAny,
+	"serverAuth":      x509.ExtKeyUsageServerAuth,
+	"clientAuth":      x509.ExtKeyUsageClientAuth,
+	"codeSigning":     x509.ExtKeyUsageCodeSigning,
+	"emailProtection": x509.ExtKeyUsageEmailProtection,
+	"timeStamping":    x509.ExtKeyUsageTimeStamping,
+	"ocspSigning":     x509.ExtKeyUsageOCSPSigning,
+}
+
+// parsePolicyOID parses a dotted-decimal OID like "2.23.140.1.2.1" into
+// an asn1.ObjectIdentifier, the form PolicyOIDs is declared in.
+func parsePolicyOID(value string) (asn1.ObjectIdentifier, error) {
+	parts := strings.Split(value, ".")
+	oid := make(asn1.ObjectIdentifier, len(parts))
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a dotted-decimal OID: %s", value, err)
+		}
+		oid[i] = n
+	}
+	return oid, nil
+}
+
+// applyDirectives parses the rendered output of a file template and
+// folds each recognized directive into mods. "reject: <reason>" aborts
+// issuance by returning an error. "key_usage" and "ext_key_usage" take
+// a comma-separated list of names and OR/append into mods, so a
+// template can spread them across multiple lines; "policy_oid" appends
+// a single OID and may repeat.
+func applyDirectives(templateName, rendered string, mods *core.CertificateModifications) error {
+	scanner := bufio.NewScanner(strings.NewReader(rendered))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key := strings.TrimSpace(parts[0])
+		value := strings.TrimSpace(parts[1])
+
+		switch key {
+		case "reject":
+			return core.UnauthorizedError(fmt.Sprintf("Template %s rejected issuance: %s", templateName, value))
+		case "not_after":
+			dur, err := time.ParseDuration(value)
+			if err != nil {
+				return core.InternalServerError(fmt.Sprintf("Template %s set invalid not_after: %s", templateName, err))
+			}
+			mods.NotAfter = time.Now().Add(dur)
+		case "ct_poison":
+			mods.CTPoison = value == "true"
+		case "key_usage":
+			for _, name := range strings.Split(value, ",") {
+				name = strings.TrimSpace(name)
+				usage, ok := keyUsageNames[name]
+				if !ok {
+					return core.InternalServerError(fmt.Sprintf("Template %s set unknown key_usage %q", templateName, name))
+				}
+				mods.KeyUsage |= usage
+			}
+		case "ext_key_usage":
+			for _, name := range strings.Split(value, ",") {
+				name = strings.TrimSpace(name)
+				usage, ok := extKeyUsageNames[name]
+				if !ok {
+					return core.InternalServerError(fmt.Sprintf("Template %s set unknown ext_key_usage %q", templateName, name))
+				}
+				mods.ExtKeyUsage = append(mods.ExtKeyUsage, usage)
+			}
+		case "policy_oid":
+			oid, err := parsePolicyOID(value)
+			if err != nil {
+				return core.InternalServerError(fmt.Sprintf("Template %s set invalid policy_oid: %s", templateName, err))
+			}
+			mods.PolicyOIDs = append(mods.PolicyOIDs, oid)
+		}
+	}
+	return scanner.Err()
+}
+
+// LoadTemplatesFromDir parses every "*.tmpl" file in dir as a named
+// certificate template, for operators who want templates beyond the
+// built-in short-lived-leaf and must-staple ones.
+func LoadTemplatesFromDir(dir string) ([]core.CertificateTemplate, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.tmpl"))
+	if err != nil {
+		return nil, err
+	}
+
+	var templates []core.CertificateTemplate
+	for _, path := range paths {
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		name := strings.TrimSuffix(filepath.Base(path), ".tmpl")
+		tmpl, err := template.New(name).Funcs(templateFuncs).Parse(string(contents))
+		if err != nil {
+			return nil, core.MalformedRequestError(fmt.Sprintf("Could not parse template %s: %s", name, err))
+		}
+		templates = append(templates, &fileTemplate{name: name, tmpl: tmpl})
+	}
+	return templates, nil
+}
+
+// runTemplates applies each template in order, stopping at the first
+// error returned by a template (which rejects issuance).
+func runTemplates(templates []core.CertificateTemplate, ctx core.TemplateContext) (core.CertificateModifications, error) {
+	var mods core.CertificateModifications
+	for _, tmpl := range templates {
+		if err := tmpl.Apply(ctx, &mods); err != nil {
+			return mods, err
+		}
+	}
+	return mods, nil
+}
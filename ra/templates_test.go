@@ -0,0 +1,90 @@
+// Copyright 2014 ISRG.  All rights reserved
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package ra
+
+import (
+	"crypto/x509"
+	"testing"
+
+	"github.com/letsencrypt/boulder/core"
+)
+
+func TestMustStapleTemplateSetsTLSFeature(t *testing.T) {
+	var mods core.CertificateModifications
+	if err := MustStapleTemplate.Apply(core.TemplateContext{}, &mods); err != nil {
+		t.Fatalf("Apply returned error: %s", err)
+	}
+
+	if len(mods.TLSFeatures) != 1 || mods.TLSFeatures[0] != statusRequestFeature {
+		t.Errorf("TLSFeatures = %v, want [%d]", mods.TLSFeatures, statusRequestFeature)
+	}
+	if len(mods.PolicyOIDs) != 0 {
+		t.Errorf("expected must-staple to leave PolicyOIDs untouched, got %v", mods.PolicyOIDs)
+	}
+}
+
+func TestApplyDirectivesSetsKeyUsage(t *testing.T) {
+	var mods core.CertificateModifications
+	rendered := "key_usage: digitalSignature, keyEncipherment\n"
+	if err := applyDirectives("test", rendered, &mods); err != nil {
+		t.Fatalf("applyDirectives returned error: %s", err)
+	}
+
+	want := x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
+	if mods.KeyUsage != want {
+		t.Errorf("KeyUsage = %v, want %v", mods.KeyUsage, want)
+	}
+}
+
+func TestApplyDirectivesRejectsUnknownKeyUsage(t *testing.T) {
+	var mods core.CertificateModifications
+	if err := applyDirectives("test", "key_usage: notARealUsage\n", &mods); err == nil {
+		t.Fatal("expected an error for an unrecognized key_usage name")
+	}
+}
+
+func TestApplyDirectivesAppendsExtKeyUsage(t *testing.T) {
+	var mods core.CertificateModifications
+	rendered := "ext_key_usage: serverAuth\next_key_usage: clientAuth\n"
+	if err := applyDirectives("test", rendered, &mods); err != nil {
+		t.Fatalf("applyDirectives returned error: %s", err)
+	}
+
+	want := []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+	if len(mods.ExtKeyUsage) != len(want) {
+		t.Fatalf("ExtKeyUsage = %v, want %v", mods.ExtKeyUsage, want)
+	}
+	for i, eku := range want {
+		if mods.ExtKeyUsage[i] != eku {
+			t.Errorf("ExtKeyUsage[%d] = %v, want %v", i, mods.ExtKeyUsage[i], eku)
+		}
+	}
+}
+
+func TestApplyDirectivesAppendsPolicyOID(t *testing.T) {
+	var mods core.CertificateModifications
+	rendered := "policy_oid: 2.23.140.1.2.1\npolicy_oid: 1.2.3.4\n"
+	if err := applyDirectives("test", rendered, &mods); err != nil {
+		t.Fatalf("applyDirectives returned error: %s", err)
+	}
+
+	if len(mods.PolicyOIDs) != 2 {
+		t.Fatalf("PolicyOIDs = %v, want 2 entries", mods.PolicyOIDs)
+	}
+	if mods.PolicyOIDs[0].String() != "2.23.140.1.2.1" {
+		t.Errorf("PolicyOIDs[0] = %s, want 2.23.140.1.2.1", mods.PolicyOIDs[0].String())
+	}
+	if mods.PolicyOIDs[1].String() != "1.2.3.4" {
+		t.Errorf("PolicyOIDs[1] = %s, want 1.2.3.4", mods.PolicyOIDs[1].String())
+	}
+}
+
+func TestApplyDirectivesRejectsInvalidPolicyOID(t *testing.T) {
+	var mods core.CertificateModifications
+	if err := applyDirectives("test", "policy_oid: not-an-oid\n", &mods); err == nil {
+		t.Fatal("expected an error for a malformed policy_oid")
+	}
+}
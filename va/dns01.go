@@ -0,0 +1,231 @@
+// Copyright 2014 ISRG.  All rights reserved
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package va
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/letsencrypt/boulder/Godeps/_workspace/src/github.com/miekg/dns"
+	"github.com/letsencrypt/boulder/core"
+)
+
+// dns01LabelPrefix is prepended to the identifier to form the name that
+// must carry the validation TXT record, per RFC 8555 section 8.4.
+const dns01LabelPrefix = "_acme-challenge."
+
+// DNSPollInterval is how often the dns01Solver re-queries the
+// authoritative nameservers while waiting for a record to propagate.
+var DNSPollInterval = 5 * time.Second
+
+// DNSPollTimeout bounds how long the dns01Solver will poll before giving
+// up on a dns-01 validation.
+var DNSPollTimeout = 2 * time.Minute
+
+// dnsExchanger is the subset of *dns.Client used by dns01Solver. It's
+// factored out so tests can substitute a fake resolver instead of
+// making real network queries.
+type dnsExchanger interface {
+	Exchange(m *dns.Msg, addr string) (*dns.Msg, time.Duration, error)
+}
+
+// dns01Solver implements core.ChallengeSolver for the dns-01 challenge.
+// Because DNS propagation can lag, it polls the zone's authoritative
+// nameservers directly (rather than relying on a caching resolver) and
+// requires them to agree before declaring the challenge satisfied.
+type dns01Solver struct {
+	client dnsExchanger
+}
+
+func (s *dns01Solver) Present(authz core.Authorization, chal core.Challenge) error {
+	return nil
+}
+
+func (s *dns01Solver) CleanUp(authz core.Authorization, chal core.Challenge) error {
+	return nil
+}
+
+func (s *dns01Solver) Verify(authz core.Authorization, chal core.Challenge) (core.AcmeStatus, error) {
+	if authz.Identifier.Type != core.IdentifierDNS {
+		return core.StatusInvalid, core.InternalServerError("Identifier type for DNS-01 was not DNS")
+	}
+
+	keyAuth, err := chal.ExpectedKeyAuthorization()
+	if err != nil {
+		return core.StatusInvalid, core.InternalServerError("Could not compute expected key authorization: " + err.Error())
+	}
+	digest := sha256.Sum256([]byte(keyAuth))
+	expected := base64.RawURLEncoding.EncodeToString(digest[:])
+
+	name := dns01LabelPrefix + authz.Identifier.Value
+	if err := s.pollForRecord(name, expected); err != nil {
+		return core.StatusInvalid, err
+	}
+	return core.StatusValid, nil
+}
+
+// pollForRecord retries checkOnce every DNSPollInterval until it
+// succeeds or DNSPollTimeout elapses, at which point it returns the
+// last checkOnce error (or, if checkOnce simply never found a matching
+// record, an UnauthorizedError). Split out from Verify so the polling
+// and timeout behavior can be exercised directly against a fake
+// resolver without needing a real core.Challenge.
+func (s *dns01Solver) pollForRecord(name, expected string) error {
+	deadline := time.Now().Add(DNSPollTimeout)
+	for {
+		ok, err := s.checkOnce(name, expected)
+		if err == nil && ok {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			if err != nil {
+				return err
+			}
+			return core.UnauthorizedError(fmt.Sprintf("No TXT record matching the key authorization found under %s", name))
+		}
+		time.Sleep(DNSPollInterval)
+	}
+}
+
+func (s *dns01Solver) dnsClient() dnsExchanger {
+	if s.client == nil {
+		s.client = &dns.Client{}
+	}
+	return s.client
+}
+
+// checkOnce follows any CNAME at name to find the zone that should hold
+// the validation TXT record, queries every authoritative nameserver for
+// that zone directly, and requires all of them to return the expected
+// value before considering the challenge satisfied.
+func (s *dns01Solver) checkOnce(name, expected string) (bool, error) {
+	target, zone, err := s.resolveTarget(name)
+	if err != nil {
+		return false, err
+	}
+
+	nameservers, err := s.authoritativeNameservers(zone)
+	if err != nil {
+		return false, err
+	}
+	if len(nameservers) == 0 {
+		return false, core.UnauthorizedError(fmt.Sprintf("Could not find authoritative nameservers for %s", zone))
+	}
+
+	for _, ns := range nameservers {
+		values, err := s.lookupTXT(target, ns)
+		if err != nil {
+			return false, err
+		}
+		if !contains(values, expected) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// resolveTarget follows CNAMEs from name so operators can delegate
+// "_acme-challenge" to a dedicated zone, returning the name that should
+// hold the TXT record and the zone that name belongs to.
+func (s *dns01Solver) resolveTarget(name string) (target, zone string, err error) {
+	target = dns.Fqdn(name)
+	for i := 0; i < 10; i++ {
+		zone, err = s.findZone(target)
+		if err != nil {
+			return "", "", err
+		}
+		nameservers, err := s.authoritativeNameservers(zone)
+		if err != nil || len(nameservers) == 0 {
+			return target, zone, err
+		}
+		cname, err := s.lookupCNAME(target, nameservers[0])
+		if err != nil || cname == "" {
+			return target, zone, nil
+		}
+		target = dns.Fqdn(cname)
+	}
+	return target, zone, nil
+}
+
+func (s *dns01Solver) lookupCNAME(name, nameserver string) (string, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(name, dns.TypeCNAME)
+	resp, _, err := s.dnsClient().Exchange(m, nameserver+":53")
+	if err != nil {
+		return "", core.UnauthorizedError(fmt.Sprintf("Failed CNAME lookup for %s: %s", name, err))
+	}
+	for _, rr := range resp.Answer {
+		if cname, ok := rr.(*dns.CNAME); ok {
+			return cname.Target, nil
+		}
+	}
+	return "", nil
+}
+
+func (s *dns01Solver) lookupTXT(name, nameserver string) ([]string, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(name, dns.TypeTXT)
+	resp, _, err := s.dnsClient().Exchange(m, nameserver+":53")
+	if err != nil {
+		return nil, core.UnauthorizedError(fmt.Sprintf("Failed TXT lookup for %s on %s: %s", name, nameserver, err))
+	}
+	var values []string
+	for _, rr := range resp.Answer {
+		if txt, ok := rr.(*dns.TXT); ok {
+			values = append(values, strings.Join(txt.Txt, ""))
+		}
+	}
+	return values, nil
+}
+
+// findZone walks up the labels of name looking for the enclosing zone's
+// SOA record, as reported by the public resolver at 8.8.8.8.
+func (s *dns01Solver) findZone(name string) (string, error) {
+	labels := dns.SplitDomainName(name)
+	for i := range labels {
+		candidate := dns.Fqdn(strings.Join(labels[i:], "."))
+		m := new(dns.Msg)
+		m.SetQuestion(candidate, dns.TypeSOA)
+		resp, _, err := s.dnsClient().Exchange(m, "8.8.8.8:53")
+		if err != nil {
+			continue
+		}
+		if len(resp.Answer) > 0 {
+			return candidate, nil
+		}
+	}
+	return "", core.UnauthorizedError(fmt.Sprintf("Could not find zone for %s", name))
+}
+
+// authoritativeNameservers returns the hostnames of the nameservers that
+// are authoritative for zone.
+func (s *dns01Solver) authoritativeNameservers(zone string) ([]string, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(zone, dns.TypeNS)
+	resp, _, err := s.dnsClient().Exchange(m, "8.8.8.8:53")
+	if err != nil {
+		return nil, core.UnauthorizedError(fmt.Sprintf("Failed NS lookup for %s: %s", zone, err))
+	}
+	var nameservers []string
+	for _, rr := range resp.Answer {
+		if ns, ok := rr.(*dns.NS); ok {
+			nameservers = append(nameservers, ns.Ns)
+		}
+	}
+	return nameservers, nil
+}
+
+func contains(haystack []string, needle string) bool {
+	for _, v := range haystack {
+		if v == needle {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,169 @@
+// Copyright 2014 ISRG.  All rights reserved
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package va
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/letsencrypt/boulder/Godeps/_workspace/src/github.com/miekg/dns"
+)
+
+// fakeExchanger answers DNS queries from canned responses keyed by
+// "nameserver qtype qname", so tests can script CNAME chains, SOA/NS
+// discovery, and per-nameserver disagreement without touching the
+// network.
+type fakeExchanger struct {
+	responses map[string]*dns.Msg
+	err       map[string]error
+}
+
+func (f *fakeExchanger) key(m *dns.Msg, addr string) string {
+	q := m.Question[0]
+	return fmt.Sprintf("%s %d %s", addr, q.Qtype, q.Name)
+}
+
+func (f *fakeExchanger) Exchange(m *dns.Msg, addr string) (*dns.Msg, time.Duration, error) {
+	key := f.key(m, addr)
+	if err, ok := f.err[key]; ok {
+		return nil, 0, err
+	}
+	if resp, ok := f.responses[key]; ok {
+		return resp, 0, nil
+	}
+	return &dns.Msg{}, 0, nil
+}
+
+func txtAnswer(value string) *dns.Msg {
+	return &dns.Msg{Answer: []dns.RR{&dns.TXT{Txt: []string{value}}}}
+}
+
+func soaAnswer() *dns.Msg {
+	return &dns.Msg{Answer: []dns.RR{&dns.SOA{}}}
+}
+
+func nsAnswer(hosts ...string) *dns.Msg {
+	var rrs []dns.RR
+	for _, h := range hosts {
+		rrs = append(rrs, &dns.NS{Ns: h})
+	}
+	return &dns.Msg{Answer: rrs}
+}
+
+func cnameAnswer(target string) *dns.Msg {
+	return &dns.Msg{Answer: []dns.RR{&dns.CNAME{Target: target}}}
+}
+
+func TestResolveTargetFollowsCNAME(t *testing.T) {
+	fake := &fakeExchanger{responses: map[string]*dns.Msg{
+		"8.8.8.8:53 6 example.com.":                                soaAnswer(),
+		"8.8.8.8:53 2 example.com.":                                nsAnswer("ns1.example.com."),
+		"ns1.example.com.:53 5 _acme-challenge.example.com.":       cnameAnswer("validation.example.net."),
+		"8.8.8.8:53 6 validation.example.net.":                     soaAnswer(),
+		"8.8.8.8:53 2 validation.example.net.":                     nsAnswer("ns1.validation.example.net."),
+		"ns1.validation.example.net.:53 5 validation.example.net.": &dns.Msg{},
+	}}
+	s := &dns01Solver{client: fake}
+
+	target, zone, err := s.resolveTarget("_acme-challenge.example.com")
+	if err != nil {
+		t.Fatalf("resolveTarget returned error: %s", err)
+	}
+	if target != "validation.example.net." {
+		t.Errorf("target = %q, want %q", target, "validation.example.net.")
+	}
+	if zone != "validation.example.net." {
+		t.Errorf("zone = %q, want %q", zone, "validation.example.net.")
+	}
+}
+
+func TestFindZoneWalksUpLabels(t *testing.T) {
+	fake := &fakeExchanger{responses: map[string]*dns.Msg{
+		"8.8.8.8:53 6 a.b.example.com.": {},
+		"8.8.8.8:53 6 b.example.com.":   {},
+		"8.8.8.8:53 6 example.com.":     soaAnswer(),
+	}}
+	s := &dns01Solver{client: fake}
+
+	zone, err := s.findZone("a.b.example.com.")
+	if err != nil {
+		t.Fatalf("findZone returned error: %s", err)
+	}
+	if zone != "example.com." {
+		t.Errorf("zone = %q, want %q", zone, "example.com.")
+	}
+}
+
+func TestFindZoneNotFound(t *testing.T) {
+	s := &dns01Solver{client: &fakeExchanger{responses: map[string]*dns.Msg{}}}
+
+	if _, err := s.findZone("example.com."); err == nil {
+		t.Error("expected error when no SOA is ever found")
+	}
+}
+
+func TestCheckOnceRequiresAllNameserversToAgree(t *testing.T) {
+	fake := &fakeExchanger{responses: map[string]*dns.Msg{
+		"8.8.8.8:53 6 example.com.":                           soaAnswer(),
+		"8.8.8.8:53 2 example.com.":                           nsAnswer("ns1.example.com.", "ns2.example.com."),
+		"ns1.example.com.:53 16 _acme-challenge.example.com.": txtAnswer("expected-value"),
+		"ns2.example.com.:53 16 _acme-challenge.example.com.": txtAnswer("stale-value"),
+	}}
+	s := &dns01Solver{client: fake}
+
+	ok, err := s.checkOnce("_acme-challenge.example.com", "expected-value")
+	if err != nil {
+		t.Fatalf("checkOnce returned error: %s", err)
+	}
+	if ok {
+		t.Error("expected checkOnce to fail when nameservers disagree")
+	}
+}
+
+func TestCheckOnceSucceedsWhenAllNameserversAgree(t *testing.T) {
+	fake := &fakeExchanger{responses: map[string]*dns.Msg{
+		"8.8.8.8:53 6 example.com.":                           soaAnswer(),
+		"8.8.8.8:53 2 example.com.":                           nsAnswer("ns1.example.com.", "ns2.example.com."),
+		"ns1.example.com.:53 16 _acme-challenge.example.com.": txtAnswer("expected-value"),
+		"ns2.example.com.:53 16 _acme-challenge.example.com.": txtAnswer("expected-value"),
+	}}
+	s := &dns01Solver{client: fake}
+
+	ok, err := s.checkOnce("_acme-challenge.example.com", "expected-value")
+	if err != nil {
+		t.Fatalf("checkOnce returned error: %s", err)
+	}
+	if !ok {
+		t.Error("expected checkOnce to succeed when all nameservers agree")
+	}
+}
+
+func TestPollForRecordGivesUpAfterTimeout(t *testing.T) {
+	origInterval, origTimeout := DNSPollInterval, DNSPollTimeout
+	DNSPollInterval = time.Millisecond
+	DNSPollTimeout = 5 * time.Millisecond
+	defer func() { DNSPollInterval, DNSPollTimeout = origInterval, origTimeout }()
+
+	// No SOA ever answers, so findZone always fails and pollForRecord
+	// must give up once DNSPollTimeout elapses rather than looping
+	// forever.
+	s := &dns01Solver{client: &fakeExchanger{responses: map[string]*dns.Msg{}}}
+
+	done := make(chan error)
+	go func() {
+		done <- s.pollForRecord("_acme-challenge.example.com.", "expected-value")
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("expected an error once the poll deadline passes")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("pollForRecord did not return within the poll timeout")
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2014 ISRG.  All rights reserved
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package va
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/letsencrypt/boulder/core"
+)
+
+// dvsniSolver implements core.ChallengeSolver for the legacy dvsni
+// challenge: it dials the identifier and expects the presented
+// certificate to bear a SAN derived from the key authorization. Whether
+// that certificate is self-signed is not checked.
+type dvsniSolver struct{}
+
+func (s *dvsniSolver) Present(authz core.Authorization, chal core.Challenge) error {
+	return nil
+}
+
+func (s *dvsniSolver) CleanUp(authz core.Authorization, chal core.Challenge) error {
+	return nil
+}
+
+func (s *dvsniSolver) Verify(authz core.Authorization, chal core.Challenge) (core.AcmeStatus, error) {
+	if err := validateDvsni(authz.Identifier, chal); err != nil {
+		return core.StatusInvalid, err
+	}
+	return core.StatusValid, nil
+}
+
+func validateDvsni(identifier core.AcmeIdentifier, chal core.Challenge) error {
+	if identifier.Type != core.IdentifierDNS {
+		return core.InternalServerError("Identifier type for DVSNI was not DNS")
+	}
+
+	keyAuth, err := chal.ExpectedKeyAuthorization()
+	if err != nil {
+		return core.InternalServerError("Could not compute expected key authorization: " + err.Error())
+	}
+	digest := sha256.Sum256([]byte(keyAuth))
+	expectedSAN := fmt.Sprintf("%s.acme.invalid", hex.EncodeToString(digest[:16]))
+
+	conn, err := tls.Dial("tcp", identifier.Value+":443", &tls.Config{
+		ServerName:         expectedSAN,
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		return core.UnauthorizedError(fmt.Sprintf("Failed to connect to %s for DVSNI challenge: %s", identifier.Value, err))
+	}
+	defer conn.Close()
+
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return core.UnauthorizedError(fmt.Sprintf("No certs presented for DVSNI challenge to %s", identifier.Value))
+	}
+	leaf := certs[0]
+
+	for _, name := range leaf.DNSNames {
+		if name == expectedSAN {
+			return nil
+		}
+	}
+	return core.UnauthorizedError(fmt.Sprintf("Incorrect validation certificate for %s: missing %s in SANs", identifier.Value, expectedSAN))
+}
@@ -0,0 +1,37 @@
+// Copyright 2014 ISRG.  All rights reserved
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package va
+
+import (
+	"github.com/letsencrypt/boulder/core"
+)
+
+// solvers maps a challenge type to the core.ChallengeSolver that knows
+// how to present, verify, and clean it up. Operators can add solvers for
+// additional challenge types (e.g. dns-01 via RFC 2136 dynamic updates)
+// by calling RegisterSolver without modifying the VA or core packages.
+var solvers = map[string]core.ChallengeSolver{}
+
+// RegisterSolver associates a challenge type with the solver that
+// implements it. It is typically called from an init() function, either
+// in this package for built-in solvers or in an operator's own package
+// for custom ones, before the VA begins serving validations.
+func RegisterSolver(challengeType string, solver core.ChallengeSolver) {
+	solvers[challengeType] = solver
+}
+
+// solverFor looks up the registered solver for a challenge type.
+func solverFor(challengeType string) (core.ChallengeSolver, bool) {
+	solver, ok := solvers[challengeType]
+	return solver, ok
+}
+
+func init() {
+	RegisterSolver(core.ChallengeTypeSimpleHTTPS, &simpleHTTPSSolver{})
+	RegisterSolver(core.ChallengeTypeDVSNI, &dvsniSolver{})
+	RegisterSolver(core.ChallengeTypeTLSALPN01, &tlsALPNSolver{})
+	RegisterSolver(core.ChallengeTypeDNS01, &dns01Solver{})
+}
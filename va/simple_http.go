@@ -0,0 +1,52 @@
+// Copyright 2014 ISRG.  All rights reserved
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package va
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/letsencrypt/boulder/core"
+)
+
+// simpleHTTPSSolver implements core.ChallengeSolver for the simpleHttps
+// challenge: it fetches a well-known URL on the identifier and compares
+// the response body to the challenge's key authorization.
+type simpleHTTPSSolver struct{}
+
+func (s *simpleHTTPSSolver) Present(authz core.Authorization, chal core.Challenge) error {
+	return nil
+}
+
+func (s *simpleHTTPSSolver) CleanUp(authz core.Authorization, chal core.Challenge) error {
+	return nil
+}
+
+func (s *simpleHTTPSSolver) Verify(authz core.Authorization, chal core.Challenge) (core.AcmeStatus, error) {
+	url := fmt.Sprintf("https://%s/.well-known/acme-challenge/%s", authz.Identifier.Value, chal.Token)
+	resp, err := http.Get(url)
+	if err != nil {
+		return core.StatusInvalid, core.UnauthorizedError(fmt.Sprintf("Failed to connect to %s: %s", authz.Identifier.Value, err))
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return core.StatusInvalid, core.UnauthorizedError(fmt.Sprintf("Failed to read response from %s: %s", authz.Identifier.Value, err))
+	}
+
+	expected, err := chal.ExpectedKeyAuthorization()
+	if err != nil {
+		return core.StatusInvalid, core.InternalServerError("Could not compute expected key authorization: " + err.Error())
+	}
+
+	if string(body) != expected {
+		return core.StatusInvalid, core.UnauthorizedError(fmt.Sprintf("Incorrect response from %s", url))
+	}
+
+	return core.StatusValid, nil
+}
@@ -0,0 +1,127 @@
+// Copyright 2014 ISRG.  All rights reserved
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package va
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+
+	"github.com/letsencrypt/boulder/core"
+)
+
+// idPeACMEIdentifierOID is the critical certificate extension OID defined
+// by RFC 8737 for carrying the key authorization digest.
+var idPeACMEIdentifierOID = asn1.ObjectIdentifier(core.IdPeACMEIdentifier)
+
+// validateTLSALPN performs the TLS-ALPN-01 validation described in RFC
+// 8737: it dials the identifier on port 443, negotiating the
+// "acme-tls/1" ALPN protocol with SNI set to the identifier, and checks
+// that the presented certificate is self-signed, has a SAN matching the
+// identifier, and carries a critical id-pe-acmeIdentifier extension
+// whose value is the SHA-256 digest of the key authorization.
+func validateTLSALPN(identifier core.AcmeIdentifier, input core.Challenge) error {
+	if identifier.Type != core.IdentifierDNS {
+		return core.InternalServerError("Identifier type for TLS-ALPN-01 was not DNS")
+	}
+
+	conn, err := tls.Dial("tcp", identifier.Value+":443", &tls.Config{
+		ServerName:         identifier.Value,
+		NextProtos:         []string{core.TLSALPNProtocol},
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		return core.UnauthorizedError(fmt.Sprintf("Failed to connect to %s for TLS-ALPN-01 challenge: %s", identifier.Value, err))
+	}
+	defer conn.Close()
+
+	if conn.ConnectionState().NegotiatedProtocol != core.TLSALPNProtocol {
+		return core.UnauthorizedError(fmt.Sprintf("Server at %s did not negotiate %s", identifier.Value, core.TLSALPNProtocol))
+	}
+
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) != 1 {
+		return core.UnauthorizedError(fmt.Sprintf("Expected exactly one cert for TLS-ALPN-01 challenge to %s, got %d", identifier.Value, len(certs)))
+	}
+	leaf := certs[0]
+
+	authorizedKeyAuth, err := input.ExpectedKeyAuthorization()
+	if err != nil {
+		return core.InternalServerError("Could not compute expected key authorization: " + err.Error())
+	}
+	expectedDigest := sha256.Sum256([]byte(authorizedKeyAuth))
+
+	return checkACMEIdentifierCert(leaf, identifier.Value, expectedDigest)
+}
+
+// checkACMEIdentifierCert validates a TLS-ALPN-01 answering certificate
+// against the identifier being proven and the expected key authorization
+// digest: it must be self-signed, carry a SAN matching the identifier,
+// and carry a critical id-pe-acmeIdentifier extension containing the
+// digest. It is pure (no I/O) so it can be exercised directly in tests.
+func checkACMEIdentifierCert(leaf *x509.Certificate, identifierValue string, expectedDigest [32]byte) error {
+	// CheckSignature (not CheckSignatureFrom) is used here: CheckSignatureFrom
+	// additionally enforces CA semantics (IsCA, BasicConstraintsValid,
+	// KeyUsage&CertSign) on the "parent", which a TLS-ALPN-01 answering
+	// cert is never issued with. All self-signed means here is "the
+	// signature verifies against the cert's own public key."
+	if err := leaf.CheckSignature(leaf.SignatureAlgorithm, leaf.RawTBSCertificate, leaf.Signature); err != nil {
+		return core.UnauthorizedError(fmt.Sprintf("Incorrect validation certificate for %s: not self-signed: %s", identifierValue, err))
+	}
+
+	foundName := false
+	for _, name := range leaf.DNSNames {
+		if name == identifierValue {
+			foundName = true
+			break
+		}
+	}
+	if !foundName {
+		return core.UnauthorizedError(fmt.Sprintf("Incorrect validation certificate for %s: SAN does not match", identifierValue))
+	}
+
+	for _, ext := range leaf.Extensions {
+		if !ext.Id.Equal(idPeACMEIdentifierOID) {
+			continue
+		}
+		if !ext.Critical {
+			return core.UnauthorizedError(fmt.Sprintf("Incorrect validation certificate for %s: acmeIdentifier extension not critical", identifierValue))
+		}
+		var digest []byte
+		if _, err := asn1.Unmarshal(ext.Value, &digest); err != nil {
+			return core.UnauthorizedError(fmt.Sprintf("Incorrect validation certificate for %s: malformed acmeIdentifier extension", identifierValue))
+		}
+		if !bytes.Equal(digest, expectedDigest[:]) {
+			return core.UnauthorizedError(fmt.Sprintf("Incorrect validation certificate for %s: acmeIdentifier digest mismatch", identifierValue))
+		}
+		return nil
+	}
+
+	return core.UnauthorizedError(fmt.Sprintf("Incorrect validation certificate for %s: missing acmeIdentifier extension", identifierValue))
+}
+
+// tlsALPNSolver implements core.ChallengeSolver for the TLS-ALPN-01
+// challenge. There is nothing to provision ahead of time; the answering
+// certificate is expected to already be served by the identifier.
+type tlsALPNSolver struct{}
+
+func (s *tlsALPNSolver) Present(authz core.Authorization, chal core.Challenge) error {
+	return nil
+}
+
+func (s *tlsALPNSolver) CleanUp(authz core.Authorization, chal core.Challenge) error {
+	return nil
+}
+
+func (s *tlsALPNSolver) Verify(authz core.Authorization, chal core.Challenge) (core.AcmeStatus, error) {
+	if err := validateTLSALPN(authz.Identifier, chal); err != nil {
+		return core.StatusInvalid, err
+	}
+	return core.StatusValid, nil
+}
@@ -0,0 +1,107 @@
+// Copyright 2014 ISRG.  All rights reserved
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package va
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// makeTestCert builds a self-signed certificate for identifierValue,
+// optionally carrying the acmeIdentifier extension for digest (or no
+// extension at all if acmeDigest is nil), for use across the test cases
+// below.
+func makeTestCert(t *testing.T, identifierValue string, acmeDigest []byte, critical bool) *x509.Certificate {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating test key: %s", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: identifierValue},
+		DNSNames:     []string{identifierValue},
+		NotBefore:    time.Unix(0, 0),
+		NotAfter:     time.Unix(0, 0).Add(24 * time.Hour),
+	}
+
+	if acmeDigest != nil {
+		value, err := asn1.Marshal(acmeDigest)
+		if err != nil {
+			t.Fatalf("marshaling acmeIdentifier extension: %s", err)
+		}
+		template.ExtraExtensions = []pkix.Extension{
+			{
+				Id:       idPeACMEIdentifierOID,
+				Critical: critical,
+				Value:    value,
+			},
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("creating test cert: %s", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parsing test cert: %s", err)
+	}
+	return cert
+}
+
+func TestCheckACMEIdentifierCertValid(t *testing.T) {
+	digest := sha256.Sum256([]byte("key-authorization"))
+	cert := makeTestCert(t, "example.com", digest[:], true)
+
+	if err := checkACMEIdentifierCert(cert, "example.com", digest); err != nil {
+		t.Errorf("expected valid cert to pass, got error: %s", err)
+	}
+}
+
+func TestCheckACMEIdentifierCertWrongName(t *testing.T) {
+	digest := sha256.Sum256([]byte("key-authorization"))
+	cert := makeTestCert(t, "example.com", digest[:], true)
+
+	if err := checkACMEIdentifierCert(cert, "other.example.com", digest); err == nil {
+		t.Error("expected name mismatch to be rejected")
+	}
+}
+
+func TestCheckACMEIdentifierCertNotCritical(t *testing.T) {
+	digest := sha256.Sum256([]byte("key-authorization"))
+	cert := makeTestCert(t, "example.com", digest[:], false)
+
+	if err := checkACMEIdentifierCert(cert, "example.com", digest); err == nil {
+		t.Error("expected non-critical acmeIdentifier extension to be rejected")
+	}
+}
+
+func TestCheckACMEIdentifierCertWrongDigest(t *testing.T) {
+	digest := sha256.Sum256([]byte("key-authorization"))
+	wrongDigest := sha256.Sum256([]byte("some-other-authorization"))
+	cert := makeTestCert(t, "example.com", digest[:], true)
+
+	if err := checkACMEIdentifierCert(cert, "example.com", wrongDigest); err == nil {
+		t.Error("expected digest mismatch to be rejected")
+	}
+}
+
+func TestCheckACMEIdentifierCertMissingExtension(t *testing.T) {
+	digest := sha256.Sum256([]byte("key-authorization"))
+	cert := makeTestCert(t, "example.com", nil, false)
+
+	if err := checkACMEIdentifierCert(cert, "example.com", digest); err == nil {
+		t.Error("expected missing acmeIdentifier extension to be rejected")
+	}
+}
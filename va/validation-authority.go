@@ -0,0 +1,74 @@
+// Copyright 2014 ISRG.  All rights reserved
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package va
+
+import (
+	"fmt"
+
+	"github.com/letsencrypt/boulder/core"
+	blog "github.com/letsencrypt/boulder/log"
+)
+
+// ValidationAuthorityImpl implements core.ValidationAuthority. It drives
+// challenge validation by looking up a core.ChallengeSolver for each
+// challenge's type in the solvers registry, rather than switching on
+// challenge type inline, so new challenge types can be added by
+// registering a solver instead of patching this package.
+type ValidationAuthorityImpl struct {
+	RA core.RegistrationAuthority
+}
+
+// NewValidationAuthorityImpl creates a VA wired up to report validation
+// results back to the given RA.
+func NewValidationAuthorityImpl(ra core.RegistrationAuthority) *ValidationAuthorityImpl {
+	return &ValidationAuthorityImpl{RA: ra}
+}
+
+// UpdateValidations drives the challenge at challengeIndex — the one the
+// client just responded to — through its solver's Present, Verify, and
+// CleanUp lifecycle, then reports the result to the RA so it can
+// finalize the authorization's status. Other challenges on the
+// authorization are left untouched: validating them would be wasted
+// work at best (the client never attempted them) and, for challenges
+// like dns-01 that poll for a long time before giving up, would stall
+// this call for no reason.
+func (va *ValidationAuthorityImpl) UpdateValidations(authz core.Authorization, challengeIndex int) error {
+	if challengeIndex < 0 || challengeIndex >= len(authz.Challenges) {
+		return core.MalformedRequestError(fmt.Sprintf("Invalid challenge index %d", challengeIndex))
+	}
+	chal := authz.Challenges[challengeIndex]
+	if chal.Status != core.StatusPending {
+		va.RA.OnValidationUpdate(authz)
+		return nil
+	}
+
+	solver, ok := solverFor(chal.Type)
+	if !ok {
+		return core.NotSupportedError(fmt.Sprintf("No solver registered for challenge type %s", chal.Type))
+	}
+
+	var status core.AcmeStatus
+	if err := solver.Present(authz, chal); err != nil {
+		status = core.StatusInvalid
+	} else {
+		var verifyErr error
+		status, verifyErr = solver.Verify(authz, chal)
+		if verifyErr != nil {
+			status = core.StatusInvalid
+		}
+	}
+	// CleanUp always runs, even if Present or Verify failed, so a
+	// solver that provisioned something (e.g. a DNS record) doesn't
+	// leak it on a failed validation.
+	if cleanupErr := solver.CleanUp(authz, chal); cleanupErr != nil {
+		blog.GetAuditLogger().Warning(fmt.Sprintf(
+			"CleanUp failed for challenge type %s on %s: %s", chal.Type, authz.Identifier.Value, cleanupErr))
+	}
+	authz.Challenges[challengeIndex].Status = status
+
+	va.RA.OnValidationUpdate(authz)
+	return nil
+}
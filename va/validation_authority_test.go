@@ -0,0 +1,137 @@
+// Copyright 2014 ISRG.  All rights reserved
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package va
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/letsencrypt/boulder/core"
+)
+
+// fakeSolver records whether each lifecycle method was called and lets
+// a test control what Verify returns.
+type fakeSolver struct {
+	presented, cleanedUp bool
+	verifyStatus         core.AcmeStatus
+	verifyErr            error
+}
+
+func (f *fakeSolver) Present(authz core.Authorization, chal core.Challenge) error {
+	f.presented = true
+	return nil
+}
+
+func (f *fakeSolver) CleanUp(authz core.Authorization, chal core.Challenge) error {
+	f.cleanedUp = true
+	return nil
+}
+
+func (f *fakeSolver) Verify(authz core.Authorization, chal core.Challenge) (core.AcmeStatus, error) {
+	return f.verifyStatus, f.verifyErr
+}
+
+type fakeRA struct {
+	updated core.Authorization
+}
+
+func (f *fakeRA) OnValidationUpdate(authz core.Authorization) {
+	f.updated = authz
+}
+
+func TestUpdateValidationsCallsPresentAndCleanUp(t *testing.T) {
+	const challengeType = "test-solver"
+	solver := &fakeSolver{verifyStatus: core.StatusValid}
+	RegisterSolver(challengeType, solver)
+	defer delete(solvers, challengeType)
+
+	ra := &fakeRA{}
+	validator := NewValidationAuthorityImpl(ra)
+
+	authz := core.Authorization{
+		Challenges: []core.Challenge{
+			{Type: challengeType, Status: core.StatusPending},
+		},
+	}
+
+	if err := validator.UpdateValidations(authz, 0); err != nil {
+		t.Fatalf("UpdateValidations returned error: %s", err)
+	}
+
+	if !solver.presented {
+		t.Error("expected Present to be called")
+	}
+	if !solver.cleanedUp {
+		t.Error("expected CleanUp to be called")
+	}
+	if ra.updated.Challenges[0].Status != core.StatusValid {
+		t.Errorf("expected challenge to be marked valid, got %v", ra.updated.Challenges[0].Status)
+	}
+}
+
+func TestUpdateValidationsMarksInvalidOnVerifyError(t *testing.T) {
+	const challengeType = "test-solver-invalid"
+	solver := &fakeSolver{verifyErr: errors.New("boom")}
+	RegisterSolver(challengeType, solver)
+	defer delete(solvers, challengeType)
+
+	ra := &fakeRA{}
+	validator := NewValidationAuthorityImpl(ra)
+
+	authz := core.Authorization{
+		Challenges: []core.Challenge{
+			{Type: challengeType, Status: core.StatusPending},
+		},
+	}
+
+	if err := validator.UpdateValidations(authz, 0); err != nil {
+		t.Fatalf("UpdateValidations returned error: %s", err)
+	}
+	if !solver.cleanedUp {
+		t.Error("expected CleanUp to run even when Verify fails")
+	}
+	if ra.updated.Challenges[0].Status != core.StatusInvalid {
+		t.Errorf("expected challenge to be marked invalid, got %v", ra.updated.Challenges[0].Status)
+	}
+}
+
+func TestUpdateValidationsOnlyTouchesRespondedChallenge(t *testing.T) {
+	const respondedType = "test-solver-responded"
+	const otherType = "test-solver-untouched"
+	responded := &fakeSolver{verifyStatus: core.StatusValid}
+	other := &fakeSolver{verifyStatus: core.StatusValid}
+	RegisterSolver(respondedType, responded)
+	RegisterSolver(otherType, other)
+	defer delete(solvers, respondedType)
+	defer delete(solvers, otherType)
+
+	ra := &fakeRA{}
+	validator := NewValidationAuthorityImpl(ra)
+
+	authz := core.Authorization{
+		Challenges: []core.Challenge{
+			{Type: respondedType, Status: core.StatusPending},
+			{Type: otherType, Status: core.StatusPending},
+		},
+	}
+
+	if err := validator.UpdateValidations(authz, 0); err != nil {
+		t.Fatalf("UpdateValidations returned error: %s", err)
+	}
+
+	if !responded.presented || !responded.cleanedUp {
+		t.Error("expected the responded-to challenge's solver to run")
+	}
+	if other.presented || other.cleanedUp {
+		t.Error("expected the other challenge's solver not to run")
+	}
+	if ra.updated.Challenges[0].Status != core.StatusValid {
+		t.Errorf("expected responded challenge to be marked valid, got %v", ra.updated.Challenges[0].Status)
+	}
+	if ra.updated.Challenges[1].Status != core.StatusPending {
+		t.Errorf("expected untouched challenge to remain pending, got %v", ra.updated.Challenges[1].Status)
+	}
+}